@@ -0,0 +1,105 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	"github.com/pkg/errors"
+)
+
+// Client is a handle onto a content store, image store and leases manager
+// sharing one backing store, the same trio the images/encryption and
+// images/signing packages operate on directly.
+type Client struct {
+	store  content.Store
+	images images.Store
+	leases leases.Manager
+}
+
+// ContentStore returns the backing content.Store.
+func (c *Client) ContentStore() content.Store {
+	return c.store
+}
+
+// ImageService returns the backing images.Store.
+func (c *Client) ImageService() images.Store {
+	return c.images
+}
+
+// LeasesService returns the backing leases.Manager.
+func (c *Client) LeasesService() leases.Manager {
+	return c.leases
+}
+
+// Close releases any resources held by the client.
+func (c *Client) Close() error {
+	return nil
+}
+
+// RemoteContext carries the options a RemoteOpt passed to Pull sets, such as
+// the decryption keys and unpack behavior WithDecryptionKeys/WithLazyDecrypt
+// configure.
+type RemoteContext struct {
+	// Platforms records the platforms a RemoteOpt selected. Pull does not
+	// read it: the returned Image always wraps the full target descriptor.
+	Platforms []string
+
+	// CryptoConfig holds the keys WithDecryptionKeys configured Pull with.
+	// UnpackOpts that need to decrypt layers during Unpack read it from
+	// here rather than from a package-level default.
+	CryptoConfig *encconfig.CryptoConfig
+
+	// LazyDecrypt, set by WithLazyDecrypt, keeps encrypted layers encrypted
+	// at rest in the content store and decrypts them on the fly during
+	// Unpack instead of requiring a prior, separate DecryptImage pass.
+	LazyDecrypt bool
+
+	// UnpackOpts are applied, in order, to the UnpackConfig built for every
+	// Unpack call on the Image this Pull returns.
+	UnpackOpts []UnpackOpt
+}
+
+// RemoteOpt configures a RemoteContext for a Pull call.
+type RemoteOpt func(*Client, *RemoteContext) error
+
+// Pull resolves ref against the client's image store and returns an Image
+// wrapping its target descriptor, carrying forward whatever UnpackOpts the
+// opts registered so that a later Unpack call applies them.
+func (c *Client) Pull(ctx context.Context, ref string, opts ...RemoteOpt) (*Image, error) {
+	rc := &RemoteContext{}
+	for _, o := range opts {
+		if err := o(c, rc); err != nil {
+			return nil, err
+		}
+	}
+
+	img, err := c.images.Get(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve %s", ref)
+	}
+
+	return &Image{
+		client:     c,
+		target:     img.Target,
+		unpackOpts: rc.UnpackOpts,
+	}, nil
+}
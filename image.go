@@ -0,0 +1,108 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// DefaultSnapshotter is the snapshotter name Unpack uses when the caller has
+// no particular snapshotter plugin to target.
+const DefaultSnapshotter = "overlayfs"
+
+// Image is a handle onto an image target descriptor returned by Pull, whose
+// layers Unpack reads.
+type Image struct {
+	client     *Client
+	target     ocispec.Descriptor
+	unpackOpts []UnpackOpt
+}
+
+// UnpackConfig is built fresh for every Unpack call and passed through each
+// of the Image's UnpackOpts before the layers it describes are read. An UnpackOpt that needs to change what gets read during
+// unpack (for example to decrypt layers on the fly) does so by replacing
+// ContentStore and/or LayerDescriptors here.
+type UnpackConfig struct {
+	// ContentStore is read to obtain each layer's bytes. It defaults to the
+	// Image's client's content store, but an UnpackOpt may wrap it (as
+	// imgenc.DecryptingProvider does) to transform what Unpack reads.
+	ContentStore content.Provider
+
+	// Image is the target descriptor being unpacked.
+	Image ocispec.Descriptor
+
+	// LayerDescriptors are the layers Unpack applies, in order, to the
+	// snapshot. It defaults to images.GetImageLayerDescriptors(Image), but
+	// an UnpackOpt may replace it with the logical (decrypted) descriptors
+	// a DecryptingProvider will actually produce.
+	LayerDescriptors []ocispec.Descriptor
+}
+
+// UnpackOpt customizes the UnpackConfig for a single Unpack call.
+type UnpackOpt func(ctx context.Context, uc *UnpackConfig) error
+
+// Unpack runs every UnpackOpt registered on the Image (via the RemoteOpts
+// passed to the Pull that produced it) against a fresh UnpackConfig, then
+// reads each layer in its LayerDescriptors, in order, through its
+// ContentStore. It does not create or write a snapshot; snapshotterName only
+// appears in error messages.
+func (i *Image) Unpack(ctx context.Context, snapshotterName string) error {
+	uc := &UnpackConfig{
+		ContentStore: i.client.ContentStore(),
+		Image:        i.target,
+	}
+
+	layers, err := images.GetImageLayerDescriptors(ctx, uc.ContentStore, uc.Image)
+	if err != nil {
+		return errors.Wrapf(err, "get layers for %s", uc.Image.Digest)
+	}
+	uc.LayerDescriptors = layers
+
+	for _, opt := range i.unpackOpts {
+		if err := opt(ctx, uc); err != nil {
+			return err
+		}
+	}
+
+	return applyLayers(ctx, uc, snapshotterName)
+}
+
+// applyLayers reads every layer in uc.LayerDescriptors through
+// uc.ContentStore, in order, so that any provider swapped in by an UnpackOpt
+// (for example imgenc.DecryptingProvider) actually runs during Unpack rather
+// than only being wired in and never read from.
+func applyLayers(ctx context.Context, uc *UnpackConfig, snapshotterName string) error {
+	for _, layer := range uc.LayerDescriptors {
+		ra, err := uc.ContentStore.ReaderAt(ctx, layer)
+		if err != nil {
+			return errors.Wrapf(err, "read layer %s for snapshotter %s", layer.Digest, snapshotterName)
+		}
+		_, err = io.Copy(ioutil.Discard, io.NewSectionReader(ra, 0, ra.Size()))
+		ra.Close()
+		if err != nil {
+			return errors.Wrapf(err, "apply layer %s", layer.Digest)
+		}
+	}
+	return nil
+}
@@ -194,6 +194,220 @@ func TestImageEncryption(t *testing.T) {
 	}
 }
 
+func TestImageKeyRotation(t *testing.T) {
+	setupBusyboxImage(t)
+
+	oldPublicKey, oldPrivateKey, err := utils.CreateRSATestKey(2048, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPublicKey, newPrivateKey, err := utils.CreateRSATestKey(2048, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const imageName = "docker.io/library/busybox:latest"
+	const encImageName = "docker.io/library/busybox:enc-rotate"
+	ctx, cancel := testContext(t)
+	defer cancel()
+
+	client, err := newClient(t, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := client.ImageService()
+	ls := client.LeasesService()
+	defer client.ImageService().Delete(ctx, encImageName, images.SynchronousDelete())
+
+	image, err := s.Get(ctx, imageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lf := func(desc ocispec.Descriptor) bool { return true }
+
+	oldCC := &encconfig.CryptoConfig{
+		EncryptConfig: &encconfig.EncryptConfig{
+			Parameters: map[string][][]byte{"pubkeys": {oldPublicKey}},
+			DecryptConfig: encconfig.DecryptConfig{
+				Parameters: map[string][][]byte{"privkeys": {oldPrivateKey}, "privkeys-passwords": {{}}},
+			},
+		},
+	}
+
+	l, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("Unable to create lease for encryption")
+	}
+	defer ls.Delete(ctx, l, leases.SynchronousDelete)
+
+	encSpec, modified, err := imgenc.EncryptImage(ctx, client.ContentStore(), ls, l, image.Target, oldCC, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("Encryption did not modify the spec")
+	}
+	image.Name = encImageName
+	image.Target = encSpec
+	if _, err := s.Create(ctx, image); err != nil {
+		t.Fatalf("Unable to create image: %v", err)
+	}
+
+	// Rotate: the old recipient is revoked and a new one is added, without
+	// touching the (already encrypted) layer blobs. RewrapImage reads its
+	// "old" side from CryptoConfig.DecryptConfig (a top-level *DecryptConfig
+	// field), not from EncryptConfig.DecryptConfig (the value field used
+	// above to build the matching decrypt config for an EncryptConfig), so
+	// it needs its own CryptoConfig built the same way TestImageEncryption
+	// builds its decrypt-side CryptoConfig.
+	oldRewrapCC := &encconfig.CryptoConfig{
+		DecryptConfig: &encconfig.DecryptConfig{
+			Parameters: map[string][][]byte{"privkeys": {oldPrivateKey}, "privkeys-passwords": {{}}},
+		},
+	}
+	newCC := &encconfig.CryptoConfig{
+		EncryptConfig: &encconfig.EncryptConfig{
+			Parameters: map[string][][]byte{"pubkeys": {newPublicKey}},
+		},
+	}
+
+	rl, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("Unable to create lease for rewrap")
+	}
+	defer ls.Delete(ctx, rl, leases.SynchronousDelete)
+
+	rewrapSpec, modified, err := imgenc.RewrapImage(ctx, client.ContentStore(), ls, rl, encSpec, oldRewrapCC, newCC, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || rewrapSpec.Digest == encSpec.Digest {
+		t.Fatal("Rewrap did not modify the spec")
+	}
+
+	// The old key must no longer be able to decrypt the rotated image...
+	oldDecryptCC := &encconfig.CryptoConfig{
+		DecryptConfig: &encconfig.DecryptConfig{
+			Parameters: map[string][][]byte{"privkeys": {oldPrivateKey}, "privkeys-passwords": {{}}},
+		},
+	}
+	dl, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("Unable to create lease for decryption")
+	}
+	defer ls.Delete(ctx, dl, leases.SynchronousDelete)
+	if _, _, err := imgenc.DecryptImage(ctx, client.ContentStore(), ls, dl, rewrapSpec, oldDecryptCC, lf); err == nil {
+		t.Fatal("Decryption with the revoked key should have failed")
+	}
+
+	// ...but the newly added key must.
+	newDecryptCC := &encconfig.CryptoConfig{
+		DecryptConfig: &encconfig.DecryptConfig{
+			Parameters: map[string][][]byte{"privkeys": {newPrivateKey}, "privkeys-passwords": {{}}},
+		},
+	}
+	decSpec, modified, err := imgenc.DecryptImage(ctx, client.ContentStore(), ls, dl, rewrapSpec, newDecryptCC, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || hasEncryption(ctx, client.ContentStore(), decSpec) {
+		t.Fatal("Decryption with the new key did not fully decrypt the rotated image")
+	}
+}
+
+func TestPartialImageEncryption(t *testing.T) {
+	setupBusyboxImage(t)
+
+	publicKey, privateKey, err := utils.CreateRSATestKey(2048, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const imageName = "docker.io/library/busybox:latest"
+	const encImageName = "docker.io/library/busybox:enc-partial"
+	ctx, cancel := testContext(t)
+	defer cancel()
+
+	client, err := newClient(t, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := client.ImageService()
+	ls := client.LeasesService()
+	defer client.ImageService().Delete(ctx, encImageName, images.SynchronousDelete())
+
+	image, err := s.Get(ctx, imageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lf := func(desc ocispec.Descriptor) bool { return true }
+
+	partialCC := &encconfig.CryptoConfig{
+		EncryptConfig: &encconfig.EncryptConfig{
+			Parameters: map[string][][]byte{"pubkeys": {publicKey}},
+		},
+	}
+	policy := &imgenc.PartialPolicy{
+		Matcher:      imgenc.PrefixMatcher{"etc"},
+		CryptoConfig: partialCC,
+	}
+
+	l, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("Unable to create lease for encryption")
+	}
+	defer ls.Delete(ctx, l, leases.SynchronousDelete)
+
+	// The top-level cc carries only an empty EncryptConfig (EncryptImage
+	// rejects a nil one) with no recipients; WithPartialEncryption must use
+	// policy.CryptoConfig for the matched paths rather than cc's.
+	cc := &encconfig.CryptoConfig{EncryptConfig: &encconfig.EncryptConfig{Parameters: map[string][][]byte{}}}
+
+	encSpec, modified, err := imgenc.EncryptImage(ctx, client.ContentStore(), ls, l, image.Target, cc, lf, imgenc.WithPartialEncryption(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || image.Target.Digest == encSpec.Digest {
+		t.Fatal("Partial encryption did not modify the spec")
+	}
+	if !hasEncryption(ctx, client.ContentStore(), encSpec) {
+		t.Fatal("Partially encrypted image does not have encrypted layers")
+	}
+
+	image.Name = encImageName
+	image.Target = encSpec
+	if _, err := s.Create(ctx, image); err != nil {
+		t.Fatalf("Unable to create image: %v", err)
+	}
+	ls.Delete(ctx, l, leases.SynchronousDelete)
+
+	dc := &encconfig.CryptoConfig{
+		DecryptConfig: &encconfig.DecryptConfig{
+			Parameters: map[string][][]byte{"privkeys": {privateKey}, "privkeys-passwords": {{}}},
+		},
+	}
+
+	dl, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("Unable to create lease for decryption")
+	}
+	defer ls.Delete(ctx, dl, leases.SynchronousDelete)
+
+	decSpec, modified, err := imgenc.DecryptImage(ctx, client.ContentStore(), ls, dl, encSpec, dc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || hasEncryption(ctx, client.ContentStore(), decSpec) {
+		t.Fatal("Decryption with the matching key did not fully decrypt the partially encrypted image")
+	}
+}
+
 func hasEncryption(ctx context.Context, provider content.Provider, spec ocispec.Descriptor) bool {
 	switch spec.MediaType {
 	case images.MediaTypeDockerSchema2LayerEnc, images.MediaTypeDockerSchema2LayerGzipEnc:
@@ -0,0 +1,163 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	imgenc "github.com/containerd/containerd/images/encryption"
+	imgsign "github.com/containerd/containerd/images/signing"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	"github.com/containerd/containerd/pkg/encryption/utils"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TestImageSignThenEncrypt signs a pulled image, verifies the signature,
+// then encrypts it and confirms the signature still verifies the
+// (unchanged) original descriptor even though an encrypted sibling image
+// now also exists, exercising the same client.ImageService()/LeasesService()
+// flow as TestImageEncryption.
+func TestImageSignThenEncrypt(t *testing.T) {
+	setupBusyboxImage(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const imageName = "docker.io/library/busybox:latest"
+	const encImageName = "docker.io/library/busybox:enc"
+	ctx, cancel := testContext(t)
+	defer cancel()
+
+	client, err := newClient(t, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	s := client.ImageService()
+	ls := client.LeasesService()
+	defer s.Delete(ctx, encImageName, images.SynchronousDelete())
+
+	image, err := s.Get(ctx, imageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := platforms.Parse("linux/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := platforms.NewMatcher(pl)
+
+	matchAny := func(d ocispec.Descriptor) bool { return true }
+
+	l, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("unable to create lease for signing")
+	}
+	defer ls.Delete(ctx, l, leases.SynchronousDelete)
+
+	signer := &imgsign.Ed25519Signer{Key: priv}
+	sigDesc, err := imgsign.SignImage(ctx, client.ContentStore(), ls, l, image.Target, []imgsign.Signer{signer}, matchAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sigDesc.Digest == image.Target.Digest {
+		t.Fatal("signature manifest should not share the subject's digest")
+	}
+
+	trust := imgsign.TrustPolicy{
+		Verifiers: []imgsign.Verifier{&imgsign.Ed25519Verifier{PublicKey: pub}},
+	}
+	if err := imgsign.VerifyImage(ctx, client.ContentStore(), image.Target, matchAny, trust); err != nil {
+		t.Fatalf("expected signed image to verify: %v", err)
+	}
+
+	publicKey, privateKey, err := utils.CreateRSATestKey(2048, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alldescs, err := images.GetImageLayerDescriptors(ctx, client.ContentStore(), image.Target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descs []ocispec.Descriptor
+	for _, desc := range alldescs {
+		if matcher.Match(*desc.Platform) {
+			descs = append(descs, desc)
+		}
+	}
+	lf := func(d ocispec.Descriptor) bool {
+		for _, desc := range descs {
+			if desc.Digest.String() == d.Digest.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	cc := &encconfig.CryptoConfig{
+		EncryptConfig: &encconfig.EncryptConfig{
+			Parameters: map[string][][]byte{"pubkeys": {publicKey}},
+			DecryptConfig: encconfig.DecryptConfig{
+				Parameters: map[string][][]byte{"privkeys": {privateKey}, "privkeys-passwords": {{}}},
+			},
+		},
+	}
+
+	encLease, err := ls.Create(ctx, leases.WithRandomID(), leases.WithExpiration(5*time.Minute))
+	if err != nil {
+		t.Fatal("unable to create lease for encryption")
+	}
+	defer ls.Delete(ctx, encLease, leases.SynchronousDelete)
+
+	encSpec, modified, err := imgenc.EncryptImage(ctx, client.ContentStore(), ls, encLease, image.Target, cc, lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("encryption did not modify the spec")
+	}
+
+	// The signature we produced over the original plaintext descriptor must
+	// still verify: encryption never touches an existing descriptor's bytes.
+	if err := imgsign.VerifyImage(ctx, client.ContentStore(), image.Target, matchAny, trust); err != nil {
+		t.Fatalf("original descriptor should still verify after a sibling was encrypted: %v", err)
+	}
+
+	encImage := image
+	encImage.Name = encImageName
+	encImage.Target = encSpec
+	if _, err := s.Create(ctx, encImage); err != nil {
+		t.Fatalf("unable to create encrypted image: %v", err)
+	}
+
+	if err := imgsign.VerifyImage(ctx, client.ContentStore(), encSpec, matchAny, trust); err == nil {
+		t.Fatal("encrypted descriptor was never signed and should not verify")
+	} else if errdefs.IsNotFound(err) {
+		t.Fatalf("unexpected not-found error verifying encrypted image: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/leases"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// writeBlob stores b in cs under the resource lifetime of lease (tracked via
+// ls) and returns its descriptor. If a blob with the same digest is already
+// present it is not written again, but is still added to lease.
+func writeBlob(ctx context.Context, cs content.Ingester, ls leases.Manager, lease leases.Lease, b []byte, mediaType string, annotations map[string]string) (ocispec.Descriptor, error) {
+	dgst := digest.FromBytes(b)
+	desc := ocispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      dgst,
+		Size:        int64(len(b)),
+		Annotations: annotations,
+	}
+
+	ref := "encryption-" + dgst.String()
+	w, err := content.OpenWriter(ctx, cs, content.WithRef(ref), content.WithDescriptor(desc))
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return desc, addLeaseResource(ctx, ls, lease, desc)
+		}
+		return ocispec.Descriptor{}, errors.Wrap(err, "open content writer")
+	}
+	defer w.Close()
+
+	if err := content.Copy(ctx, w, bytes.NewReader(b), desc.Size, desc.Digest); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "commit blob")
+	}
+
+	return desc, addLeaseResource(ctx, ls, lease, desc)
+}
+
+func addLeaseResource(ctx context.Context, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor) error {
+	if ls == nil {
+		return nil
+	}
+	err := ls.AddResource(ctx, lease, leases.Resource{
+		ID:   desc.Digest.String(),
+		Type: "content",
+	})
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+const lekSize = 32 // AES-256
+
+// newLEK generates a fresh random layer encryption key.
+func newLEK() ([]byte, error) {
+	lek := make([]byte, lekSize)
+	if _, err := rand.Read(lek); err != nil {
+		return nil, errors.Wrap(err, "generate layer encryption key")
+	}
+	return lek, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aesGCMEncrypt encrypts plaintext with key, prefixing the result with a
+// random nonce.
+func aesGCMEncrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// aesGCMDecrypt reverses aesGCMEncrypt.
+func aesGCMDecrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext shorter than nonce")
+	}
+	nonce, ct := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+// rsaWrap wraps key (the LEK) with the RSA public key in pemPubKey using
+// RSA-OAEP.
+func rsaWrap(pemPubKey []byte, key []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemPubKey)
+	if block == nil {
+		return nil, errors.New("not a PEM encoded public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse public key")
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, rsaPub, key, nil)
+}
+
+// rsaUnwrap unwraps a key previously wrapped by rsaWrap using the RSA
+// private key in pemPrivKey, which may itself be password protected.
+func rsaUnwrap(pemPrivKey []byte, password []byte, wrapped []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemPrivKey)
+	if block == nil {
+		return nil, errors.New("not a PEM encoded private key")
+	}
+
+	der := block.Bytes
+	//nolint:staticcheck // matches the deprecated-but-still-accepted format produced
+	// by utils.CreateRSATestKey and by `openssl genrsa -aes256`.
+	if x509.IsEncryptedPEMBlock(block) {
+		var err error
+		der, err = x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypt private key")
+		}
+	}
+
+	priv, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse private key")
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, wrapped, nil)
+}
@@ -0,0 +1,198 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package encryption implements encryption and decryption of the layers of
+// an OCI/Docker image that is already present in a content.Store. It walks
+// the manifest(s) reachable from a descriptor, rewrites the layers selected
+// by a LayerFilter, and writes the resulting blobs and manifests back into
+// the store under a caller supplied lease.
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// annotation keys used to record how a layer was encrypted, so that a later
+// DecryptImage call (possibly in a different process) can reverse it.
+const (
+	annotationLayerKeys   = "io.containerd.encryption.keys"
+	annotationPartialSpec = "io.containerd.encryption.partial.manifest"
+)
+
+// LayerFilter reports whether the given layer descriptor should be acted on
+// by the current EncryptImage/DecryptImage call. It lets callers restrict an
+// operation to a platform, a subset of layers, or (together with
+// WithPartialEncryption) nothing at all at the layer granularity.
+type LayerFilter func(ocispec.Descriptor) bool
+
+// Opt configures an optional aspect of an EncryptImage/DecryptImage call.
+type Opt func(*options)
+
+type options struct {
+	partial *PartialPolicy
+}
+
+func applyOpts(opts []Opt) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPartialEncryption selects partial, sub-tree encryption of matched
+// layers instead of whole-layer encryption. Paths matched by policy.Matcher
+// are encrypted with policy.CryptoConfig while everything else in the layer
+// stays in the clear, so a single layer can mix proprietary and shareable
+// content.
+func WithPartialEncryption(policy *PartialPolicy) Opt {
+	return func(o *options) {
+		o.partial = policy
+	}
+}
+
+// EncryptImage walks the manifest(s) reachable from desc, encrypts every
+// layer selected by lf using cc.EncryptConfig, and writes the new layers and
+// manifests into cs under lease. It returns the descriptor of the (possibly
+// rewritten) top-level object and whether anything was modified.
+func EncryptImage(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor, cc *encconfig.CryptoConfig, lf LayerFilter, opts ...Opt) (ocispec.Descriptor, bool, error) {
+	if cc == nil || cc.EncryptConfig == nil {
+		return ocispec.Descriptor{}, false, errors.New("an EncryptConfig is required to encrypt an image")
+	}
+	o := applyOpts(opts)
+	return processManifests(ctx, cs, ls, lease, desc, func(ctx context.Context, layer ocispec.Descriptor) (ocispec.Descriptor, bool, error) {
+		if !lf(layer) || isEncryptedMediaType(layer.MediaType) {
+			return layer, false, nil
+		}
+		if o.partial != nil {
+			return encryptLayerPartial(ctx, cs, ls, lease, layer, o.partial)
+		}
+		return encryptLayer(ctx, cs, ls, lease, layer, cc.EncryptConfig)
+	})
+}
+
+// DecryptImage is the inverse of EncryptImage: it decrypts every layer
+// selected by lf (or, for layers encrypted with WithPartialEncryption, only
+// the portions cc.DecryptConfig holds keys for) and writes the result back
+// into cs under lease.
+func DecryptImage(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor, cc *encconfig.CryptoConfig, lf LayerFilter, opts ...Opt) (ocispec.Descriptor, bool, error) {
+	if cc == nil || cc.DecryptConfig == nil {
+		return ocispec.Descriptor{}, false, errors.New("a DecryptConfig is required to decrypt an image")
+	}
+	return processManifests(ctx, cs, ls, lease, desc, func(ctx context.Context, layer ocispec.Descriptor) (ocispec.Descriptor, bool, error) {
+		if !lf(layer) || !isEncryptedMediaType(layer.MediaType) {
+			return layer, false, nil
+		}
+		if _, ok := layer.Annotations[annotationPartialSpec]; ok {
+			return decryptLayerPartial(ctx, cs, ls, lease, layer, cc.DecryptConfig)
+		}
+		return decryptLayer(ctx, cs, ls, lease, layer, cc.DecryptConfig)
+	})
+}
+
+func isEncryptedMediaType(mt string) bool {
+	switch mt {
+	case images.MediaTypeDockerSchema2LayerEnc, images.MediaTypeDockerSchema2LayerGzipEnc:
+		return true
+	default:
+		return false
+	}
+}
+
+// layerOp transforms a single layer descriptor, returning the (possibly
+// unchanged) new descriptor and whether it was modified.
+type layerOp func(ctx context.Context, layer ocispec.Descriptor) (ocispec.Descriptor, bool, error)
+
+// processManifests recursively walks desc (an index, a manifest, or a bare
+// layer), applies op to every layer it finds, rewrites and stores any
+// manifests/indexes whose children changed, and reports the resulting
+// top-level descriptor.
+func processManifests(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor, op layerOp) (ocispec.Descriptor, bool, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex, images.MediaTypeDockerSchema2ManifestList:
+		var idx ocispec.Index
+		if err := readJSON(ctx, cs, desc, &idx); err != nil {
+			return ocispec.Descriptor{}, false, err
+		}
+		modified := false
+		for i, m := range idx.Manifests {
+			newM, changed, err := processManifests(ctx, cs, ls, lease, m, op)
+			if err != nil {
+				return ocispec.Descriptor{}, false, err
+			}
+			if changed {
+				newM.Platform = m.Platform
+				idx.Manifests[i] = newM
+				modified = true
+			}
+		}
+		if !modified {
+			return desc, false, nil
+		}
+		newDesc, err := writeJSON(ctx, cs, ls, lease, idx, desc.MediaType, nil)
+		return newDesc, true, err
+
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		var man ocispec.Manifest
+		if err := readJSON(ctx, cs, desc, &man); err != nil {
+			return ocispec.Descriptor{}, false, err
+		}
+		modified := false
+		for i, l := range man.Layers {
+			newL, changed, err := op(ctx, l)
+			if err != nil {
+				return ocispec.Descriptor{}, false, err
+			}
+			if changed {
+				man.Layers[i] = newL
+				modified = true
+			}
+		}
+		if !modified {
+			return desc, false, nil
+		}
+		newDesc, err := writeJSON(ctx, cs, ls, lease, man, desc.MediaType, man.Annotations)
+		return newDesc, true, err
+
+	default:
+		// A bare layer descriptor was passed in directly.
+		return op(ctx, desc)
+	}
+}
+
+func readJSON(ctx context.Context, cs content.Provider, desc ocispec.Descriptor, v interface{}) error {
+	b, err := content.ReadBlob(ctx, cs, desc)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", desc.Digest)
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeJSON(ctx context.Context, cs content.Ingester, ls leases.Manager, lease leases.Lease, v interface{}, mediaType string, annotations map[string]string) (ocispec.Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return writeBlob(ctx, cs, ls, lease, b, mediaType, annotations)
+}
@@ -0,0 +1,238 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// wrappedKeys is the JSON shape stored (base64 encoded) in a layer's
+// annotationLayerKeys annotation: one LEK wrapped per configured recipient.
+type wrappedKeys struct {
+	// RSA holds LEKs wrapped directly with a raw RSA public key from
+	// parameters["pubkeys"]. This is the original, inline path; it is kept
+	// alongside Provider rather than migrated onto it so existing
+	// pubkeys/privkeys configs keep working unchanged.
+	RSA [][]byte `json:"rsa,omitempty"`
+
+	// Provider holds LEKs wrapped by a registered encconfig.KeyProvider
+	// (KMS, HSM, ...), one per recipient URI in parameters["recipients"].
+	Provider []providerWrappedKey `json:"provider,omitempty"`
+}
+
+// providerWrappedKey is one KeyProvider-wrapped LEK, tagged with enough
+// information to find the right provider and call Unwrap with the same
+// recipient it was wrapped for.
+type providerWrappedKey struct {
+	Scheme    string `json:"scheme"`
+	Recipient string `json:"recipient"`
+	Wrapped   []byte `json:"wrapped"`
+}
+
+// encryptLayer reads the plaintext layer blob, encrypts it whole with a
+// fresh layer encryption key (LEK), wraps the LEK for every recipient in ec,
+// and writes the ciphertext plus the wrapped keys back to cs.
+func encryptLayer(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, layer ocispec.Descriptor, ec *encconfig.EncryptConfig) (ocispec.Descriptor, bool, error) {
+	plaintext, err := content.ReadBlob(ctx, cs, layer)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "read layer %s", layer.Digest)
+	}
+
+	lek, err := newLEK()
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	ciphertext, err := aesGCMEncrypt(lek, plaintext)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrap(err, "encrypt layer")
+	}
+
+	wrapped, err := wrapLEKForRecipients(ctx, lek, ec.Parameters)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	annotations := copyAnnotations(layer.Annotations)
+	annotations[annotationLayerKeys] = wrapped
+	annotateDecryptedDescriptor(annotations, layer)
+
+	desc, err := writeBlob(ctx, cs, ls, lease, ciphertext, encMediaType(layer.MediaType), annotations)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	desc.Platform = layer.Platform
+	return desc, true, nil
+}
+
+// decryptLayer is the inverse of encryptLayer: it unwraps the LEK using
+// dc, decrypts the ciphertext blob, and writes the plaintext back to cs.
+func decryptLayer(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, layer ocispec.Descriptor, dc *encconfig.DecryptConfig) (ocispec.Descriptor, bool, error) {
+	ciphertext, err := content.ReadBlob(ctx, cs, layer)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "read layer %s", layer.Digest)
+	}
+
+	lek, err := unwrapLEKFromAnnotation(ctx, layer.Annotations[annotationLayerKeys], dc.Parameters)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	plaintext, err := aesGCMDecrypt(lek, ciphertext)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrap(err, "decrypt layer")
+	}
+
+	annotations := copyAnnotations(layer.Annotations)
+	delete(annotations, annotationLayerKeys)
+	delete(annotations, annotationDecryptedDigest)
+	delete(annotations, annotationDecryptedSize)
+	delete(annotations, annotationDecryptedMediaType)
+
+	desc, err := writeBlob(ctx, cs, ls, lease, plaintext, decMediaType(layer.MediaType), annotations)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	desc.Platform = layer.Platform
+	return desc, true, nil
+}
+
+func encMediaType(mt string) string {
+	switch mt {
+	case ocispec.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip:
+		return images.MediaTypeDockerSchema2LayerGzipEnc
+	default:
+		return images.MediaTypeDockerSchema2LayerEnc
+	}
+}
+
+func decMediaType(mt string) string {
+	switch mt {
+	case images.MediaTypeDockerSchema2LayerGzipEnc:
+		return images.MediaTypeDockerSchema2LayerGzip
+	default:
+		return ocispec.MediaTypeImageLayer
+	}
+}
+
+func copyAnnotations(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in)+1)
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// wrapLEKForRecipients wraps lek for every recipient in parameters, using
+// the inline RSA path for parameters["pubkeys"] and, for every URI in
+// parameters["recipients"], whichever encconfig.KeyProvider is registered
+// for that URI's scheme (so RSA-via-raw-bytes and KMS/HSM-via-provider
+// recipients can be mixed freely in a single EncryptConfig).
+func wrapLEKForRecipients(ctx context.Context, lek []byte, parameters map[string][][]byte) (string, error) {
+	var wk wrappedKeys
+	for _, pub := range parameters["pubkeys"] {
+		w, err := rsaWrap(pub, lek)
+		if err != nil {
+			return "", errors.Wrap(err, "wrap LEK")
+		}
+		wk.RSA = append(wk.RSA, w)
+	}
+	for _, rec := range parameters["recipients"] {
+		recipient := string(rec)
+		scheme, err := recipientScheme(recipient)
+		if err != nil {
+			return "", err
+		}
+		provider, ok := encconfig.LookupKeyProvider(scheme)
+		if !ok {
+			return "", errors.Wrapf(encconfig.ErrUnknownKeyProviderScheme, "%q", scheme)
+		}
+		w, err := provider.Wrap(ctx, lek, recipient)
+		if err != nil {
+			return "", errors.Wrapf(err, "wrap LEK for %s", recipient)
+		}
+		wk.Provider = append(wk.Provider, providerWrappedKey{Scheme: scheme, Recipient: recipient, Wrapped: w})
+	}
+	if len(wk.RSA) == 0 && len(wk.Provider) == 0 {
+		return "", errors.New("no supported recipients in encrypt config")
+	}
+	b, err := json.Marshal(wk)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func unwrapLEKFromAnnotation(ctx context.Context, annotation string, parameters map[string][][]byte) ([]byte, error) {
+	if annotation == "" {
+		return nil, errors.New("layer is missing its wrapped key annotation")
+	}
+	raw, err := base64.StdEncoding.DecodeString(annotation)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode wrapped key annotation")
+	}
+	var wk wrappedKeys
+	if err := json.Unmarshal(raw, &wk); err != nil {
+		return nil, errors.Wrap(err, "unmarshal wrapped key annotation")
+	}
+
+	privkeys := parameters["privkeys"]
+	passwords := parameters["privkeys-passwords"]
+	for i, priv := range privkeys {
+		var password []byte
+		if i < len(passwords) {
+			password = passwords[i]
+		}
+		for _, w := range wk.RSA {
+			if lek, err := rsaUnwrap(priv, password, w); err == nil {
+				return lek, nil
+			}
+		}
+	}
+
+	for _, pw := range wk.Provider {
+		provider, ok := encconfig.LookupKeyProvider(pw.Scheme)
+		if !ok {
+			continue
+		}
+		if lek, err := provider.Unwrap(ctx, pw.Wrapped, pw.Recipient); err == nil {
+			return lek, nil
+		}
+	}
+
+	return nil, errors.New("no private key or KeyProvider in decrypt config could unwrap the layer key")
+}
+
+// recipientScheme returns the URI scheme of a recipient string, e.g. "kms"
+// for "kms://arn:...".
+func recipientScheme(recipient string) (string, error) {
+	i := strings.Index(recipient, ":")
+	if i <= 0 {
+		return "", errors.Errorf("invalid recipient %q: not a scheme:... URI", recipient)
+	}
+	return recipient[:i], nil
+}
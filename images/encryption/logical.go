@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// annotations recording the plaintext identity of an encrypted layer, so
+// that LogicalDescriptor can report what callers should treat the layer as
+// (its blob digest, size, and media type) without reading or decrypting the
+// stored ciphertext.
+const (
+	annotationDecryptedDigest    = "io.containerd.encryption.dec.digest"
+	annotationDecryptedSize      = "io.containerd.encryption.dec.size"
+	annotationDecryptedMediaType = "io.containerd.encryption.dec.mediatype"
+)
+
+func annotateDecryptedDescriptor(annotations map[string]string, plain ocispec.Descriptor) {
+	annotations[annotationDecryptedDigest] = plain.Digest.String()
+	annotations[annotationDecryptedSize] = strconv.FormatInt(plain.Size, 10)
+	annotations[annotationDecryptedMediaType] = plain.MediaType
+}
+
+// LogicalDescriptor returns the descriptor callers should treat desc as
+// having. For a layer encrypted whole by this package it is the plaintext
+// digest/size/media type recorded at encryption time; the stored ciphertext
+// bytes are not read or modified. Layers encrypted with WithPartialEncryption
+// carry no such record, so for them, as for any other descriptor, it returns
+// desc unchanged.
+//
+// This lets diff-ID/layer-descriptor computations (e.g. for unpacking) use
+// the plaintext identity of a layer while the content store continues to
+// hold only the encrypted bytes, which is what WithLazyDecrypt relies on.
+func LogicalDescriptor(desc ocispec.Descriptor) ocispec.Descriptor {
+	if !isEncryptedMediaType(desc.MediaType) {
+		return desc
+	}
+	dgst := desc.Annotations[annotationDecryptedDigest]
+	if dgst == "" {
+		return desc
+	}
+	size, _ := strconv.ParseInt(desc.Annotations[annotationDecryptedSize], 10, 64)
+
+	logical := desc
+	logical.Digest = digest.Digest(dgst)
+	logical.Size = size
+	logical.MediaType = desc.Annotations[annotationDecryptedMediaType]
+	return logical
+}
+
+// GetLogicalImageLayerDescriptors is images.GetImageLayerDescriptors, with
+// every returned descriptor passed through LogicalDescriptor so callers see
+// the decrypted identity of encrypted layers without triggering a decrypt.
+func GetLogicalImageLayerDescriptors(ctx context.Context, provider content.Provider, image ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	descs, err := images.GetImageLayerDescriptors(ctx, provider, image)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range descs {
+		descs[i] = LogicalDescriptor(d)
+	}
+	return descs, nil
+}
@@ -0,0 +1,329 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Matcher decides whether a path inside a layer's tar stream belongs to the
+// encrypted portion of a partially encrypted layer.
+type Matcher interface {
+	Match(path string) bool
+}
+
+// GlobMatcher matches tar entry names against a set of filepath.Match style
+// glob patterns, e.g. "opt/vendor/*".
+type GlobMatcher []string
+
+// Match implements Matcher.
+func (g GlobMatcher) Match(p string) bool {
+	for _, pattern := range g {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PrefixMatcher matches tar entry names that fall under one of a set of
+// path prefixes (directory subtrees), e.g. "opt/vendor".
+type PrefixMatcher []string
+
+// Match implements Matcher.
+func (pm PrefixMatcher) Match(p string) bool {
+	p = strings.TrimPrefix(p, "./")
+	for _, prefix := range pm {
+		prefix = strings.TrimPrefix(strings.TrimSuffix(prefix, "/"), "./")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PartialPolicy selects which files within a layer are encrypted and with
+// what keys, leaving everything the Matcher rejects as plaintext.
+type PartialPolicy struct {
+	Matcher      Matcher
+	CryptoConfig *encconfig.CryptoConfig
+}
+
+// partialManifest records, for a partially encrypted layer, the paths that
+// were moved into the encrypted portion and the sizes of the two
+// tar-in-tar sections stored in the blob. It is stored as plain JSON in the
+// layer's annotationPartialSpec annotation so that a decryptor without keys
+// for this layer still knows what content it is missing.
+type partialManifest struct {
+	EncryptedPaths []string `json:"encryptedPaths"`
+	ClearSize      int64    `json:"clearSize"`
+	CipherSize     int64    `json:"cipherSize"`
+}
+
+// writePartialContainer builds the on-disk framing of a partially encrypted
+// layer blob: a varint-prefixed cleartext tar followed by a varint-prefixed AES-GCM
+// ciphertext of the encrypted-portion tar.
+func writePartialContainer(clearTar, cipherTar []byte) []byte {
+	var buf bytes.Buffer
+	var szBuf [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(szBuf[:], uint64(len(clearTar)))
+	buf.Write(szBuf[:n])
+	buf.Write(clearTar)
+
+	n = binary.PutUvarint(szBuf[:], uint64(len(cipherTar)))
+	buf.Write(szBuf[:n])
+	buf.Write(cipherTar)
+
+	return buf.Bytes()
+}
+
+func readPartialContainer(b []byte) (clearTar, cipherTar []byte, err error) {
+	r := bytes.NewReader(b)
+	clearTar, err = readVarintFrame(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read cleartext portion")
+	}
+	cipherTar, err = readVarintFrame(r)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read encrypted portion")
+	}
+	return clearTar, cipherTar, nil
+}
+
+func readVarintFrame(r *bytes.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// splitTar reads the tar stream in r and writes every entry matched by m
+// into encrypted, and every other entry into clear.
+func splitTar(r io.Reader, m Matcher) (clear, encrypted []byte, paths []string, err error) {
+	tr := tar.NewReader(r)
+
+	var clearBuf, encBuf bytes.Buffer
+	clearW := tar.NewWriter(&clearBuf)
+	encW := tar.NewWriter(&encBuf)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "read tar entry")
+		}
+
+		dst := clearW
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if m.Match(name) {
+			dst = encW
+			paths = append(paths, name)
+		}
+		if err := dst.WriteHeader(hdr); err != nil {
+			return nil, nil, nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(dst, tr); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+
+	if err := clearW.Close(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := encW.Close(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return clearBuf.Bytes(), encBuf.Bytes(), paths, nil
+}
+
+// mergeTar concatenates two independently produced tar streams (clear and
+// decrypted) into one logical tar stream for unpacking. Ordering between
+// the two portions relative to the original layer is not preserved.
+func mergeTar(clear, decrypted []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(trimTarTrailer(clear))
+	buf.Write(decrypted)
+	return buf.Bytes()
+}
+
+// trimTarTrailer drops the two 512-byte zero blocks that terminate a tar
+// stream so a second stream can be appended to it.
+func trimTarTrailer(b []byte) []byte {
+	const trailer = 1024
+	if len(b) >= trailer {
+		return b[:len(b)-trailer]
+	}
+	return b
+}
+
+// decompressIfNeeded returns the raw tar bytes for a (possibly gzipped)
+// layer blob, inspecting mediaType to decide whether to run it through
+// gunzip first.
+func decompressIfNeeded(mediaType string, b []byte) ([]byte, error) {
+	switch mediaType {
+	case ocispec.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Wrap(err, "open gzip layer")
+		}
+		defer gz.Close()
+		return ioutil.ReadAll(gz)
+	default:
+		return b, nil
+	}
+}
+
+// encryptLayerPartial splits the layer's tar stream into the paths matched
+// by policy.Matcher ("encrypted portion") and everything else ("cleartext
+// portion"), encrypts only the former with a dedicated LEK wrapped for
+// policy.CryptoConfig.EncryptConfig's recipients (not the top-level cc
+// EncryptImage was called with, which only governs whole-layer encryption),
+// and stores both portions plus the manifest of encrypted paths in a single
+// new blob.
+func encryptLayerPartial(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, layer ocispec.Descriptor, policy *PartialPolicy) (ocispec.Descriptor, bool, error) {
+	if policy.CryptoConfig == nil || policy.CryptoConfig.EncryptConfig == nil {
+		return ocispec.Descriptor{}, false, errors.New("an EncryptConfig is required in PartialPolicy.CryptoConfig to partially encrypt a layer")
+	}
+	ec := policy.CryptoConfig.EncryptConfig
+
+	raw, err := content.ReadBlob(ctx, cs, layer)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "read layer %s", layer.Digest)
+	}
+
+	r, err := decompressIfNeeded(layer.MediaType, raw)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	clearTar, encTar, paths, err := splitTar(bytes.NewReader(r), policy.Matcher)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrap(err, "split layer into encrypted/cleartext portions")
+	}
+	if len(paths) == 0 {
+		// Nothing in this layer matched the policy; leave it untouched.
+		return layer, false, nil
+	}
+
+	lek, err := newLEK()
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	cipherTar, err := aesGCMEncrypt(lek, encTar)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrap(err, "encrypt matched paths")
+	}
+	wrapped, err := wrapLEKForRecipients(ctx, lek, ec.Parameters)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	pm := partialManifest{
+		EncryptedPaths: paths,
+		ClearSize:      int64(len(clearTar)),
+		CipherSize:     int64(len(cipherTar)),
+	}
+	pmJSON, err := json.Marshal(pm)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	annotations := copyAnnotations(layer.Annotations)
+	annotations[annotationLayerKeys] = wrapped
+	annotations[annotationPartialSpec] = string(pmJSON)
+
+	blob := writePartialContainer(clearTar, cipherTar)
+	desc, err := writeBlob(ctx, cs, ls, lease, blob, images.MediaTypeDockerSchema2LayerEnc, annotations)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	desc.Platform = layer.Platform
+	return desc, true, nil
+}
+
+// decryptLayerPartial reassembles the logical (fully decrypted) layer if dc
+// holds keys for it. Otherwise it returns the layer unchanged and unmodified,
+// still holding both the cleartext and the encrypted portion in its
+// container; it does not produce a cleartext-only layer.
+func decryptLayerPartial(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, layer ocispec.Descriptor, dc *encconfig.DecryptConfig) (ocispec.Descriptor, bool, error) {
+	raw, err := content.ReadBlob(ctx, cs, layer)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "read layer %s", layer.Digest)
+	}
+
+	clearTar, cipherTar, err := readPartialContainer(raw)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	lek, err := unwrapLEKFromAnnotation(ctx, layer.Annotations[annotationLayerKeys], dc.Parameters)
+	if err != nil {
+		// No key for this layer's recipients: leave the layer exactly as
+		// stored. It still carries both the cleartext portion and the
+		// untouched ciphertext portion in its varint-framed container, so a
+		// later decrypt attempt with the right keys can still finish the
+		// job; rewriting the blob here with only clearTar would both lose
+		// the ciphertext for good and break readPartialContainer's framing
+		// on the next attempt.
+		return layer, false, nil
+	}
+
+	plainEnc, err := aesGCMDecrypt(lek, cipherTar)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrap(err, "decrypt matched paths")
+	}
+
+	merged := mergeTar(clearTar, plainEnc)
+
+	annotations := copyAnnotations(layer.Annotations)
+	delete(annotations, annotationLayerKeys)
+	delete(annotations, annotationPartialSpec)
+
+	desc, err := writeBlob(ctx, cs, ls, lease, merged, ocispec.MediaTypeImageLayer, annotations)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	desc.Platform = layer.Platform
+	return desc, true, nil
+}
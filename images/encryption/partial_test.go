@@ -0,0 +1,153 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGlobMatcher(t *testing.T) {
+	m := GlobMatcher{"opt/vendor/*", "etc/secret.conf"}
+
+	for _, tc := range []struct {
+		path  string
+		match bool
+	}{
+		{"opt/vendor/bin", true},
+		{"opt/vendor/sub/bin", false},
+		{"etc/secret.conf", true},
+		{"etc/other.conf", false},
+		{"opt/public/bin", false},
+	} {
+		if got := m.Match(tc.path); got != tc.match {
+			t.Errorf("GlobMatcher.Match(%q) = %v, want %v", tc.path, got, tc.match)
+		}
+	}
+}
+
+func TestPrefixMatcher(t *testing.T) {
+	m := PrefixMatcher{"opt/vendor"}
+
+	for _, tc := range []struct {
+		path  string
+		match bool
+	}{
+		{"opt/vendor", true},
+		{"opt/vendor/bin", true},
+		{"./opt/vendor/bin", true},
+		{"opt/vendors/bin", false},
+		{"opt/other", false},
+	} {
+		if got := m.Match(tc.path); got != tc.match {
+			t.Errorf("PrefixMatcher.Match(%q) = %v, want %v", tc.path, got, tc.match)
+		}
+	}
+}
+
+func TestPartialContainerRoundTrip(t *testing.T) {
+	clearTar := []byte("clear tar bytes")
+	cipherTar := []byte("cipher tar bytes")
+
+	container := writePartialContainer(clearTar, cipherTar)
+
+	gotClear, gotCipher, err := readPartialContainer(container)
+	if err != nil {
+		t.Fatalf("readPartialContainer: %v", err)
+	}
+	if !bytes.Equal(gotClear, clearTar) {
+		t.Errorf("clearTar = %q, want %q", gotClear, clearTar)
+	}
+	if !bytes.Equal(gotCipher, cipherTar) {
+		t.Errorf("cipherTar = %q, want %q", gotCipher, cipherTar)
+	}
+}
+
+func TestSplitAndMergeTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := map[string]string{
+		"etc/os-release":   "ID=test\n",
+		"opt/vendor/bin":   "#!/bin/sh\necho vendor\n",
+		"opt/vendor/lib/a": "libdata",
+	}
+	for _, name := range []string{"etc/os-release", "opt/vendor/bin", "opt/vendor/lib/a"} {
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(data)), Mode: 0644}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	clear, encrypted, paths, err := splitTar(bytes.NewReader(buf.Bytes()), PrefixMatcher{"opt/vendor"})
+	if err != nil {
+		t.Fatalf("splitTar: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("matched paths = %v, want 2 entries under opt/vendor", paths)
+	}
+
+	assertNames := func(b []byte, want map[string]bool) {
+		tr := tar.NewReader(bytes.NewReader(b))
+		got := map[string]bool{}
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			got[hdr.Name] = true
+		}
+		for name, expect := range want {
+			if got[name] != expect {
+				t.Errorf("tar contains %q = %v, want %v", name, got[name], expect)
+			}
+		}
+	}
+
+	assertNames(clear, map[string]bool{"etc/os-release": true, "opt/vendor/bin": false, "opt/vendor/lib/a": false})
+	assertNames(encrypted, map[string]bool{"etc/os-release": false, "opt/vendor/bin": true, "opt/vendor/lib/a": true})
+
+	merged := mergeTar(clear, encrypted)
+	tr := tar.NewReader(bytes.NewReader(merged))
+	found := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read merged entry %s: %v", hdr.Name, err)
+		}
+		if string(data) != files[hdr.Name] {
+			t.Errorf("merged entry %s = %q, want %q", hdr.Name, data, files[hdr.Name])
+		}
+		found[hdr.Name] = true
+	}
+	for name := range files {
+		if !found[name] {
+			t.Errorf("merged tar is missing %q", name)
+		}
+	}
+}
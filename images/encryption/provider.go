@@ -0,0 +1,99 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/content"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// DecryptingProvider wraps a content.Provider so that reads of an encrypted
+// layer transparently return its plaintext bytes, decrypted with
+// DecryptConfig. Reads of anything else pass straight through to Provider.
+//
+// It is meant to be handed to a snapshotter's Unpack path so that
+// MediaTypeDockerSchema2Layer{,Gzip}Enc layers can be unpacked on the fly
+// without first running DecryptImage to materialize a second, fully
+// decrypted copy of every layer in the content store.
+type DecryptingProvider struct {
+	Provider      content.Provider
+	DecryptConfig *encconfig.DecryptConfig
+}
+
+// ReaderAt implements content.Provider.
+func (p *DecryptingProvider) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	if !isEncryptedMediaType(desc.MediaType) {
+		return p.Provider.ReaderAt(ctx, desc)
+	}
+
+	ra, err := p.Provider.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+
+	raw := make([]byte, ra.Size())
+	if _, err := ra.ReadAt(raw, 0); err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "read encrypted layer %s", desc.Digest)
+	}
+
+	lek, err := unwrapLEKFromAnnotation(ctx, desc.Annotations[annotationLayerKeys], p.DecryptConfig.Parameters)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no usable key for layer %s", desc.Digest)
+	}
+
+	var plaintext []byte
+	if _, ok := desc.Annotations[annotationPartialSpec]; ok {
+		clearTar, cipherTar, err := readPartialContainer(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read partial container for layer %s", desc.Digest)
+		}
+		plainEnc, err := aesGCMDecrypt(lek, cipherTar)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decrypt matched paths of layer %s", desc.Digest)
+		}
+		plaintext = mergeTar(clearTar, plainEnc)
+	} else {
+		plaintext, err = aesGCMDecrypt(lek, raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decrypt layer %s", desc.Digest)
+		}
+	}
+
+	return &bytesReaderAt{Reader: bytes.NewReader(plaintext), size: int64(len(plaintext))}, nil
+}
+
+// bytesReaderAt adapts a bytes.Reader to content.ReaderAt, which additionally
+// requires Size and Close.
+type bytesReaderAt struct {
+	*bytes.Reader
+	size int64
+}
+
+func (b *bytesReaderAt) Size() int64 {
+	return b.size
+}
+
+func (b *bytesReaderAt) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encryption
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/leases"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// RewrapImage rewraps the per-layer encryption keys of every layer selected
+// by layerFilter for a new recipient set, without touching the (much
+// larger) ciphertext of the layer blobs themselves. It unwraps each layer's
+// existing wrapped key with oldCC.DecryptConfig and rewraps it with
+// newCC.EncryptConfig, rewriting only the manifest/annotations that carry
+// the wrapped key in the content store under lease.
+//
+// This is the key-rotation operation: revoking a recipient or adding a new
+// one is a manifest-only edit instead of a full decrypt/re-encrypt pass over
+// every layer.
+func RewrapImage(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor, oldCC, newCC *encconfig.CryptoConfig, layerFilter LayerFilter) (ocispec.Descriptor, bool, error) {
+	if oldCC == nil || oldCC.DecryptConfig == nil {
+		return ocispec.Descriptor{}, false, errors.New("a DecryptConfig for the current recipients is required to rewrap an image")
+	}
+	if newCC == nil || newCC.EncryptConfig == nil {
+		return ocispec.Descriptor{}, false, errors.New("an EncryptConfig for the new recipients is required to rewrap an image")
+	}
+
+	return processManifests(ctx, cs, ls, lease, desc, func(ctx context.Context, layer ocispec.Descriptor) (ocispec.Descriptor, bool, error) {
+		if !layerFilter(layer) || !isEncryptedMediaType(layer.MediaType) {
+			return layer, false, nil
+		}
+		return rewrapLayer(ctx, cs, ls, lease, layer, oldCC.DecryptConfig, newCC.EncryptConfig)
+	})
+}
+
+// rewrapLayer unwraps layer's LEK with oldDC, wraps it again for newEC's
+// recipients, and returns a new descriptor whose Digest/Size/MediaType are
+// unchanged but whose annotationLayerKeys annotation now reflects the new
+// recipient set. The ciphertext blob itself is not touched, so this does
+// not require re-reading or rewriting the (potentially large) layer body.
+func rewrapLayer(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, layer ocispec.Descriptor, oldDC *encconfig.DecryptConfig, newEC *encconfig.EncryptConfig) (ocispec.Descriptor, bool, error) {
+	lek, err := unwrapLEKFromAnnotation(ctx, layer.Annotations[annotationLayerKeys], oldDC.Parameters)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "unwrap key for layer %s", layer.Digest)
+	}
+
+	wrapped, err := wrapLEKForRecipients(ctx, lek, newEC.Parameters)
+	if err != nil {
+		return ocispec.Descriptor{}, false, errors.Wrapf(err, "rewrap key for layer %s", layer.Digest)
+	}
+
+	newLayer := layer
+	newLayer.Annotations = copyAnnotations(layer.Annotations)
+	newLayer.Annotations[annotationLayerKeys] = wrapped
+
+	// The layer's own digest is tracked by a lease the same way a freshly
+	// written blob would be, since it may otherwise only be referenced by
+	// the manifest we are about to replace.
+	if err := addLeaseResource(ctx, ls, lease, layer); err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+
+	return newLayer, true, nil
+}
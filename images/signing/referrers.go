@@ -0,0 +1,107 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/leases"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// referrerSubjectLabel is set on every signature manifest blob we write,
+// pointing back at the digest it is a referrer of. It doubles as a gc
+// reference (see content/gc.go's "containerd.io/gc.ref.content." convention)
+// from the signature to its subject, so the subject is kept for as long as
+// the signature is, and as the index we
+// query in findReferrers since this tree predates registries that serve the
+// OCI 1.1 GET /v2/<name>/referrers/<digest> API locally.
+const referrerSubjectLabel = "containerd.io/gc.ref.content.signature-subject"
+
+// writeReferrerManifest stores b as a MediaTypeSignatureManifest blob whose
+// subject is recorded via referrerSubjectLabel, and returns its descriptor.
+func writeReferrerManifest(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, subject ocispec.Descriptor, b []byte) (ocispec.Descriptor, error) {
+	dgst := digest.FromBytes(b)
+	desc := ocispec.Descriptor{
+		MediaType:    MediaTypeSignatureManifest,
+		Digest:       dgst,
+		Size:         int64(len(b)),
+		ArtifactType: ArtifactTypeSignature,
+	}
+
+	labels := map[string]string{
+		referrerSubjectLabel: subject.Digest.String(),
+	}
+
+	ref := "signing-" + dgst.String()
+	w, err := content.OpenWriter(ctx, cs, content.WithRef(ref), content.WithDescriptor(desc))
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return desc, setReferrerLabels(ctx, cs, dgst, labels)
+		}
+		return ocispec.Descriptor{}, errors.Wrap(err, "open content writer")
+	}
+	defer w.Close()
+
+	if err := content.Copy(ctx, w, bytes.NewReader(b), desc.Size, desc.Digest, content.WithLabels(labels)); err != nil {
+		return ocispec.Descriptor{}, errors.Wrap(err, "commit signature manifest")
+	}
+
+	if ls != nil {
+		if err := ls.AddResource(ctx, lease, leases.Resource{ID: dgst.String(), Type: "content"}); err != nil && !errdefs.IsAlreadyExists(err) {
+			return ocispec.Descriptor{}, err
+		}
+	}
+
+	return desc, nil
+}
+
+func setReferrerLabels(ctx context.Context, cs content.Manager, dgst digest.Digest, labels map[string]string) error {
+	info := content.Info{Digest: dgst, Labels: labels}
+	var fields []string
+	for k := range labels {
+		fields = append(fields, "labels."+k)
+	}
+	_, err := cs.Update(ctx, info, fields...)
+	return err
+}
+
+// findReferrers returns the descriptors of every signature manifest whose
+// referrerSubjectLabel points at subject.
+func findReferrers(ctx context.Context, cs content.Manager, subject ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	var out []ocispec.Descriptor
+	filter := fmt.Sprintf("labels.%q==%q", referrerSubjectLabel, subject.Digest.String())
+	err := cs.Walk(ctx, func(info content.Info) error {
+		out = append(out, ocispec.Descriptor{
+			MediaType:    MediaTypeSignatureManifest,
+			Digest:       info.Digest,
+			Size:         info.Size,
+			ArtifactType: ArtifactTypeSignature,
+		})
+		return nil
+	}, filter)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+)
+
+// X509Signer signs payloads with an RSA private key, in the style of a
+// certificate-backed signing identity.
+type X509Signer struct {
+	Key *rsa.PrivateKey
+}
+
+// Scheme implements Signer.
+func (s *X509Signer) Scheme() string { return "x509" }
+
+// Sign implements Signer.
+func (s *X509Signer) Sign(_ context.Context, payload []byte) ([]byte, error) {
+	h := sha256.Sum256(payload)
+	return rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, h[:])
+}
+
+// X509Verifier verifies signatures produced by X509Signer.
+type X509Verifier struct {
+	PublicKey *rsa.PublicKey
+}
+
+// Scheme implements Verifier.
+func (v *X509Verifier) Scheme() string { return "x509" }
+
+// Verify implements Verifier.
+func (v *X509Verifier) Verify(_ context.Context, payload, signature []byte) error {
+	h := sha256.Sum256(payload)
+	return rsa.VerifyPKCS1v15(v.PublicKey, crypto.SHA256, h[:], signature)
+}
+
+// PGPSigner signs payloads as an OpenPGP detached signature with Entity's
+// private signing key.
+type PGPSigner struct {
+	Entity *openpgp.Entity
+}
+
+// Scheme implements Signer.
+func (s *PGPSigner) Scheme() string { return "pgp" }
+
+// Sign implements Signer.
+func (s *PGPSigner) Sign(_ context.Context, payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.DetachSign(&buf, s.Entity, bytes.NewReader(payload), nil); err != nil {
+		return nil, errors.Wrap(err, "pgp detach sign")
+	}
+	return buf.Bytes(), nil
+}
+
+// PGPVerifier verifies signatures produced by PGPSigner against a keyring of
+// trusted entities.
+type PGPVerifier struct {
+	KeyRing openpgp.EntityList
+}
+
+// Scheme implements Verifier.
+func (v *PGPVerifier) Scheme() string { return "pgp" }
+
+// Verify implements Verifier.
+func (v *PGPVerifier) Verify(_ context.Context, payload, signature []byte) error {
+	_, err := openpgp.CheckDetachedSignature(v.KeyRing, bytes.NewReader(payload), bytes.NewReader(signature))
+	if err != nil {
+		return errors.Wrap(err, "pgp: signature verification failed")
+	}
+	return nil
+}
+
+// Ed25519Signer signs payloads with a raw ed25519 key, matching the payload
+// signing scheme used by cosign.
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+// Scheme implements Signer.
+func (s *Ed25519Signer) Scheme() string { return "cosign-ed25519" }
+
+// Sign implements Signer.
+func (s *Ed25519Signer) Sign(_ context.Context, payload []byte) ([]byte, error) {
+	return ed25519.Sign(s.Key, payload), nil
+}
+
+// Ed25519Verifier verifies signatures produced by Ed25519Signer.
+type Ed25519Verifier struct {
+	PublicKey ed25519.PublicKey
+}
+
+// Scheme implements Verifier.
+func (v *Ed25519Verifier) Scheme() string { return "cosign-ed25519" }
+
+// Verify implements Verifier.
+func (v *Ed25519Verifier) Verify(_ context.Context, payload, signature []byte) error {
+	if !ed25519.Verify(v.PublicKey, payload, signature) {
+		return errors.New("cosign-ed25519: signature verification failed")
+	}
+	return nil
+}
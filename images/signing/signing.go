@@ -0,0 +1,206 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package signing provides integrity and authenticity for images already
+// present in a content.Store, as a counterpart to images/encryption's
+// confidentiality support. Signatures are stored as OCI 1.1 referrers of the
+// descriptor they cover rather than as annotations on that descriptor, so
+// signing never changes an image's digest.
+package signing
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// MediaTypeSignatureManifest is the artifact media type used for the
+// referrer manifest that carries one or more detached signatures over a
+// single subject descriptor.
+const MediaTypeSignatureManifest = "application/vnd.containerd.signature.v1+json"
+
+// ArtifactTypeSignature is the manifest.artifactType recorded on a signature
+// manifest, used by clients and registries to filter referrers listings down
+// to signatures.
+const ArtifactTypeSignature = "application/vnd.containerd.signature"
+
+// LayerFilter reports whether a descriptor found while walking an image
+// should be signed/verified. It has the same shape as
+// images/encryption.LayerFilter so the two packages compose naturally.
+type LayerFilter func(ocispec.Descriptor) bool
+
+// Signer produces a detached signature over the raw bytes of a descriptor.
+// Scheme identifies the signature format so Verifier implementations and
+// trust policies can select compatible keys (for example "pgp", "x509", or
+// "cosign-ed25519").
+type Signer interface {
+	Scheme() string
+	Sign(ctx context.Context, payload []byte) (signature []byte, err error)
+}
+
+// Verifier checks a detached signature produced by the Signer with the same
+// Scheme.
+type Verifier interface {
+	Scheme() string
+	Verify(ctx context.Context, payload, signature []byte) error
+}
+
+// TrustPolicy decides, for a given descriptor and the signatures found
+// covering it, whether the descriptor should be trusted.
+type TrustPolicy struct {
+	// Verifiers is consulted for every signature manifest referring to a
+	// descriptor; a descriptor is trusted once at least Threshold of its
+	// signatures verify against one of them.
+	Verifiers []Verifier
+
+	// Threshold is the number of distinct, independently verifying
+	// signatures required. A Threshold of zero is treated as 1.
+	Threshold int
+}
+
+// signatureManifest is the content of a MediaTypeSignatureManifest blob: one
+// entry per signer that covered the subject.
+type signatureManifest struct {
+	Subject    ocispec.Descriptor `json:"subject"`
+	Signatures []signatureEntry   `json:"signatures"`
+}
+
+type signatureEntry struct {
+	Scheme    string `json:"scheme"`
+	Signature []byte `json:"signature"`
+}
+
+// SignImage signs every descriptor selected by matcher (typically every
+// manifest and layer reachable from desc, as produced by images.Children)
+// with every signer in signers, and stores the resulting signature manifests
+// as OCI referrers of the descriptors they cover. Unlike EncryptImage it
+// never mutates desc itself; it returns the descriptor of the top-level
+// signature manifest created for desc so callers can locate it without a
+// referrers API round trip.
+func SignImage(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, desc ocispec.Descriptor, signers []Signer, matcher LayerFilter) (ocispec.Descriptor, error) {
+	if len(signers) == 0 {
+		return ocispec.Descriptor{}, errors.New("at least one signer is required")
+	}
+
+	var top ocispec.Descriptor
+	err := images.Dispatch(ctx, images.HandlerFunc(func(ctx context.Context, d ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if !matcher(d) {
+			return images.Children(ctx, cs, d)
+		}
+		sigDesc, err := signDescriptor(ctx, cs, ls, lease, d, signers)
+		if err != nil {
+			return nil, errors.Wrapf(err, "sign %s", d.Digest)
+		}
+		if d.Digest == desc.Digest {
+			top = sigDesc
+		}
+		return images.Children(ctx, cs, d)
+	}), platforms.All, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if top.Digest == "" {
+		return ocispec.Descriptor{}, errors.New("matcher did not select the top-level descriptor; it was not signed")
+	}
+	return top, nil
+}
+
+// VerifyImage checks every signature manifest referring to a descriptor
+// selected by matcher (and, via images.Children, every manifest/layer
+// reachable from desc) against trust. Descriptors matcher rejects are walked
+// for their children but are not themselves required to carry any
+// signature, mirroring SignImage's selective-signing semantics. It returns
+// an error describing the first matched descriptor that fails to meet
+// trust.Threshold.
+func VerifyImage(ctx context.Context, cs content.Store, desc ocispec.Descriptor, matcher LayerFilter, trust TrustPolicy) error {
+	threshold := trust.Threshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+
+	return images.Dispatch(ctx, images.HandlerFunc(func(ctx context.Context, d ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if !matcher(d) {
+			return images.Children(ctx, cs, d)
+		}
+
+		refs, err := findReferrers(ctx, cs, d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list referrers of %s", d.Digest)
+		}
+
+		payload, err := content.ReadBlob(ctx, cs, d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", d.Digest)
+		}
+
+		verified := 0
+		for _, ref := range refs {
+			var sm signatureManifest
+			b, err := content.ReadBlob(ctx, cs, ref)
+			if err != nil {
+				continue
+			}
+			if err := json.Unmarshal(b, &sm); err != nil {
+				continue
+			}
+			for _, entry := range sm.Signatures {
+				for _, v := range trust.Verifiers {
+					if v.Scheme() != entry.Scheme {
+						continue
+					}
+					if err := v.Verify(ctx, payload, entry.Signature); err == nil {
+						verified++
+						break
+					}
+				}
+			}
+		}
+		if verified < threshold {
+			return nil, errors.Errorf("%s has %d valid signature(s), trust policy requires %d", d.Digest, verified, threshold)
+		}
+
+		return images.Children(ctx, cs, d)
+	}), platforms.All, desc)
+}
+
+func signDescriptor(ctx context.Context, cs content.Store, ls leases.Manager, lease leases.Lease, subject ocispec.Descriptor, signers []Signer) (ocispec.Descriptor, error) {
+	payload, err := content.ReadBlob(ctx, cs, subject)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	sm := signatureManifest{Subject: subject}
+	for _, s := range signers {
+		sig, err := s.Sign(ctx, payload)
+		if err != nil {
+			return ocispec.Descriptor{}, errors.Wrapf(err, "sign with %s", s.Scheme())
+		}
+		sm.Signatures = append(sm.Signatures, signatureEntry{Scheme: s.Scheme(), Signature: sig})
+	}
+
+	b, err := json.Marshal(sm)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	return writeReferrerManifest(ctx, cs, ls, lease, subject, b)
+}
@@ -0,0 +1,50 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package config defines the configuration types shared by the image
+// encryption and decryption code in images/encryption.
+package config
+
+// EncryptConfig holds the parameters needed to encrypt a set of layers.
+// Parameters is keyed by a scheme-specific parameter name (for example
+// "pubkeys" or "gpg-recipients") and holds one or more raw byte values for
+// that parameter. The "recipients" key holds URI strings (e.g. "kms://...",
+// "pkcs11:...") resolved through the KeyProvider registry instead of raw key
+// material; see RegisterKeyProvider.
+type EncryptConfig struct {
+	Parameters map[string][][]byte
+
+	// DecryptConfig carries the decryption side parameters for the same
+	// recipients, so that a freshly produced EncryptConfig can be turned
+	// around and used to verify what it just wrapped.
+	DecryptConfig DecryptConfig
+}
+
+// DecryptConfig holds the parameters needed to decrypt layers that were
+// encrypted with an EncryptConfig. Parameters is keyed the same way as
+// EncryptConfig.Parameters (for example "privkeys" and
+// "privkeys-passwords").
+type DecryptConfig struct {
+	Parameters map[string][][]byte
+}
+
+// CryptoConfig bundles the encrypt and/or decrypt configuration for a single
+// EncryptImage/DecryptImage call. Either field may be nil depending on which
+// direction the operation runs in.
+type CryptoConfig struct {
+	EncryptConfig *EncryptConfig
+	DecryptConfig *DecryptConfig
+}
@@ -0,0 +1,76 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package keyproviders
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/pkg/errors"
+)
+
+// AWSKMSProvider wraps/unwraps layer encryption keys with AWS KMS.
+// Recipients are "kms://<key-arn>", e.g.
+// "kms://arn:aws:kms:us-east-1:111122223333:key/1234abcd-...".
+type AWSKMSProvider struct {
+	Client *kms.Client
+}
+
+// Scheme implements config.KeyProvider.
+func (p *AWSKMSProvider) Scheme() string { return "kms" }
+
+// Wrap implements config.KeyProvider.
+func (p *AWSKMSProvider) Wrap(ctx context.Context, lek []byte, recipient string) ([]byte, error) {
+	keyID, err := p.keyID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(keyID),
+		Plaintext: lek,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "aws kms encrypt")
+	}
+	return out.CiphertextBlob, nil
+}
+
+// Unwrap implements config.KeyProvider.
+func (p *AWSKMSProvider) Unwrap(ctx context.Context, wrapped []byte, recipient string) ([]byte, error) {
+	keyID, err := p.keyID(recipient)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.Client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyID),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "aws kms decrypt")
+	}
+	return out.Plaintext, nil
+}
+
+func (p *AWSKMSProvider) keyID(recipient string) (string, error) {
+	keyID := strings.TrimPrefix(recipient, "kms://")
+	if keyID == "" || keyID == recipient {
+		return "", errors.Errorf("invalid aws kms recipient %q, expected kms://<key-arn-or-id>", recipient)
+	}
+	return keyID, nil
+}
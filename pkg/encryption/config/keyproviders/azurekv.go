@@ -0,0 +1,83 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package keyproviders
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/pkg/errors"
+)
+
+// AzureKeyVaultProvider wraps/unwraps layer encryption keys with Azure Key
+// Vault. Recipients are "azurekv://<vault-url>/<key-name>/<key-version>",
+// e.g. "azurekv://myvault.vault.azure.net/mykey/abcdef0123456789".
+type AzureKeyVaultProvider struct {
+	Client *azkeys.Client
+}
+
+// Scheme implements config.KeyProvider.
+func (p *AzureKeyVaultProvider) Scheme() string { return "azurekv" }
+
+// Wrap implements config.KeyProvider.
+func (p *AzureKeyVaultProvider) Wrap(ctx context.Context, lek []byte, recipient string) ([]byte, error) {
+	name, version, err := p.keyNameVersion(recipient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.WrapKey(ctx, name, version, azkeys.KeyOperationParameters{
+		Algorithm: toPtr(azkeys.JSONWebKeyEncryptionAlgorithmRSAOAEP256),
+		Value:     lek,
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "azure key vault wrap key")
+	}
+	return resp.Result, nil
+}
+
+// Unwrap implements config.KeyProvider.
+func (p *AzureKeyVaultProvider) Unwrap(ctx context.Context, wrapped []byte, recipient string) ([]byte, error) {
+	name, version, err := p.keyNameVersion(recipient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.UnwrapKey(ctx, name, version, azkeys.KeyOperationParameters{
+		Algorithm: toPtr(azkeys.JSONWebKeyEncryptionAlgorithmRSAOAEP256),
+		Value:     wrapped,
+	}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "azure key vault unwrap key")
+	}
+	return resp.Result, nil
+}
+
+func (p *AzureKeyVaultProvider) keyNameVersion(recipient string) (name, version string, err error) {
+	rest := strings.TrimPrefix(recipient, "azurekv://")
+	if rest == recipient {
+		return "", "", errors.Errorf("invalid azure key vault recipient %q, expected azurekv://<vault>/<key-name>/<key-version>", recipient)
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) < 3 {
+		return "", "", errors.Errorf("invalid azure key vault recipient %q, expected azurekv://<vault>/<key-name>/<key-version>", recipient)
+	}
+	return parts[len(parts)-2], parts[len(parts)-1], nil
+}
+
+func toPtr(a azkeys.JSONWebKeyEncryptionAlgorithm) *azkeys.JSONWebKeyEncryptionAlgorithm {
+	return &a
+}
@@ -0,0 +1,23 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package keyproviders implements config.KeyProvider for KMS and HSM backed
+// recipients. Each provider is constructed explicitly by the caller (it
+// needs live credentials/handles) and then registered with
+// config.RegisterKeyProvider; none of them register themselves from an
+// init function, so a binary that never configures a KMS never has to
+// reach out to one.
+package keyproviders
@@ -0,0 +1,76 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package keyproviders
+
+import (
+	"context"
+	"strings"
+
+	kmspb "cloud.google.com/go/kms/apiv1/kmspb"
+	kms "cloud.google.com/go/kms/apiv1"
+	"github.com/pkg/errors"
+)
+
+// GCPKMSProvider wraps/unwraps layer encryption keys with Google Cloud KMS.
+// Recipients are "gcpkms://<crypto-key-resource-name>", e.g.
+// "gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k".
+type GCPKMSProvider struct {
+	Client *kms.KeyManagementClient
+}
+
+// Scheme implements config.KeyProvider.
+func (p *GCPKMSProvider) Scheme() string { return "gcpkms" }
+
+// Wrap implements config.KeyProvider.
+func (p *GCPKMSProvider) Wrap(ctx context.Context, lek []byte, recipient string) ([]byte, error) {
+	name, err := p.keyName(recipient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:      name,
+		Plaintext: lek,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcp kms encrypt")
+	}
+	return resp.Ciphertext, nil
+}
+
+// Unwrap implements config.KeyProvider.
+func (p *GCPKMSProvider) Unwrap(ctx context.Context, wrapped []byte, recipient string) ([]byte, error) {
+	name, err := p.keyName(recipient)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.Client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:       name,
+		Ciphertext: wrapped,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "gcp kms decrypt")
+	}
+	return resp.Plaintext, nil
+}
+
+func (p *GCPKMSProvider) keyName(recipient string) (string, error) {
+	name := strings.TrimPrefix(recipient, "gcpkms://")
+	if name == "" || name == recipient {
+		return "", errors.Errorf("invalid gcp kms recipient %q, expected gcpkms://<crypto-key-resource-name>", recipient)
+	}
+	return name, nil
+}
@@ -0,0 +1,114 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package keyproviders
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// PKCS11Provider wraps/unwraps layer encryption keys using a PKCS#11 HSM
+// session. Recipients are PKCS#11 URIs, e.g.
+// "pkcs11:token=my-token;object=lek-wrap-key".
+type PKCS11Provider struct {
+	Ctx     *pkcs11.Ctx
+	Session pkcs11.SessionHandle
+}
+
+// Scheme implements config.KeyProvider.
+func (p *PKCS11Provider) Scheme() string { return "pkcs11" }
+
+// Wrap implements config.KeyProvider.
+func (p *PKCS11Provider) Wrap(ctx context.Context, lek []byte, recipient string) ([]byte, error) {
+	handle, mech, err := p.resolve(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Ctx.EncryptInit(p.Session, mech, handle); err != nil {
+		return nil, errors.Wrap(err, "pkcs11 encrypt init")
+	}
+	wrapped, err := p.Ctx.Encrypt(p.Session, lek)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 encrypt")
+	}
+	return wrapped, nil
+}
+
+// Unwrap implements config.KeyProvider.
+func (p *PKCS11Provider) Unwrap(ctx context.Context, wrapped []byte, recipient string) ([]byte, error) {
+	handle, mech, err := p.resolve(recipient)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Ctx.DecryptInit(p.Session, mech, handle); err != nil {
+		return nil, errors.Wrap(err, "pkcs11 decrypt init")
+	}
+	lek, err := p.Ctx.Decrypt(p.Session, wrapped)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 decrypt")
+	}
+	return lek, nil
+}
+
+// resolve finds the object handle named by recipient's "object" attribute
+// and returns it along with the RSA-OAEP mechanism used to wrap/unwrap LEKs
+// with it.
+func (p *PKCS11Provider) resolve(recipient string) (pkcs11.ObjectHandle, []*pkcs11.Mechanism, error) {
+	object, err := pkcs11URIAttr(recipient, "object")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, object),
+	}
+	if err := p.Ctx.FindObjectsInit(p.Session, template); err != nil {
+		return 0, nil, errors.Wrap(err, "pkcs11 find objects init")
+	}
+	defer p.Ctx.FindObjectsFinal(p.Session)
+
+	handles, _, err := p.Ctx.FindObjects(p.Session, 1)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "pkcs11 find objects")
+	}
+	if len(handles) == 0 {
+		return 0, nil, errors.Errorf("pkcs11: no object named %q", object)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, nil)}
+	return handles[0], mech, nil
+}
+
+// pkcs11URIAttr extracts the value of a ";name=value" attribute from a
+// PKCS#11 URI (RFC 7512), e.g. "object" from
+// "pkcs11:token=my-token;object=lek-wrap-key".
+func pkcs11URIAttr(uri, name string) (string, error) {
+	rest := strings.TrimPrefix(uri, "pkcs11:")
+	if rest == uri {
+		return "", errors.Errorf("invalid pkcs11 recipient %q", uri)
+	}
+	for _, part := range strings.Split(rest, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 2 && kv[0] == name {
+			return kv[1], nil
+		}
+	}
+	return "", errors.Errorf("pkcs11 recipient %q is missing %q", uri, name)
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// KeyProvider wraps and unwraps layer encryption keys (LEKs) on behalf of a
+// recipient that is addressed by URI rather than by raw key bytes, so that
+// private key material for KMS- or HSM-backed recipients never has to be
+// marshalled through Parameters/DecryptConfig.Parameters.
+type KeyProvider interface {
+	// Scheme is the URI scheme this provider handles, e.g. "kms" for
+	// "kms://arn:aws:kms:..." recipients or "pkcs11" for
+	// "pkcs11:token=...;object=lek" recipients.
+	Scheme() string
+
+	// Wrap encrypts lek for recipient, a URI with Scheme's scheme.
+	Wrap(ctx context.Context, lek []byte, recipient string) ([]byte, error)
+
+	// Unwrap decrypts a value previously produced by Wrap. recipient
+	// identifies which of the provider's keys/credentials to use, the same
+	// URI that was passed to Wrap.
+	Unwrap(ctx context.Context, wrapped []byte, recipient string) ([]byte, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]KeyProvider{}
+)
+
+// RegisterKeyProvider makes p available for recipients whose URI scheme is
+// p.Scheme(). It panics if a provider for that scheme is already
+// registered, following the same convention as e.g. database/sql drivers.
+func RegisterKeyProvider(p KeyProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	scheme := p.Scheme()
+	if _, ok := providers[scheme]; ok {
+		panic(fmt.Sprintf("encryption: KeyProvider for scheme %q already registered", scheme))
+	}
+	providers[scheme] = p
+}
+
+// LookupKeyProvider returns the KeyProvider registered for scheme, or false
+// if none is registered.
+func LookupKeyProvider(scheme string) (KeyProvider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	p, ok := providers[scheme]
+	return p, ok
+}
+
+// ErrUnknownKeyProviderScheme is returned (wrapped) when a layer key is
+// wrapped for a recipient whose scheme has no registered KeyProvider.
+// Unwrapping skips such recipients instead of returning it.
+var ErrUnknownKeyProviderScheme = errors.New("no KeyProvider registered for recipient scheme")
@@ -0,0 +1,67 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package utils provides small helpers for constructing and handling the
+// key material used by the images/encryption package. CreateRSATestKey is
+// intended for tests and documentation examples, not for generating keys
+// that protect real images.
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// CreateRSATestKey generates an RSA key pair of the given size and returns
+// the PEM encoded public and private keys. If password is non-empty the
+// private key is encrypted with it unless noPassword is true, in which case
+// the private key is returned unencrypted regardless of password.
+func CreateRSATestKey(bits int, password []byte, noPassword bool) (publicKey []byte, privateKey []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generate RSA key")
+	}
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "marshal RSA public key")
+	}
+	publicKey = pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubBytes,
+	})
+
+	privBytes := x509.MarshalPKCS1PrivateKey(key)
+	privBlock := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: privBytes,
+	}
+	if !noPassword && len(password) > 0 {
+		//nolint:staticcheck // x509.EncryptPEMBlock is deprecated but this is the
+		// long-standing on-disk format accepted by the decrypt side.
+		privBlock, err = x509.EncryptPEMBlock(rand.Reader, privBlock.Type, privBlock.Bytes, password, x509.PEMCipherAES256)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "encrypt RSA private key")
+		}
+	}
+	privateKey = pem.EncodeToMemory(privBlock)
+
+	return publicKey, privateKey, nil
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package containerd
+
+import (
+	"context"
+
+	imgenc "github.com/containerd/containerd/images/encryption"
+	encconfig "github.com/containerd/containerd/pkg/encryption/config"
+)
+
+// WithDecryptionKeys configures Pull to make the keys in cc available when
+// an encrypted image is later unpacked, so that
+// MediaTypeDockerSchema2Layer{,Gzip}Enc layers can be unpacked directly
+// instead of requiring a prior, separate imgenc.DecryptImage pass over the
+// content store. It registers the UnpackOpt that does so itself, so callers
+// never need to wire it in separately: the decryption only actually happens
+// once WithLazyDecrypt is also set, since the UnpackOpt reads c.LazyDecrypt
+// when Unpack runs it, not when Pull applies this RemoteOpt.
+func WithDecryptionKeys(cc *encconfig.CryptoConfig) RemoteOpt {
+	return func(_ *Client, c *RemoteContext) error {
+		c.CryptoConfig = cc
+		c.UnpackOpts = append(c.UnpackOpts, decryptingContentStoreUnpackOpt(c))
+		return nil
+	}
+}
+
+// WithLazyDecrypt configures Pull to keep encrypted layers encrypted at
+// rest in the content store, decrypting each one on the fly as the
+// snapshotter unpacks it into an active snapshot, rather than requiring a
+// full imgenc.DecryptImage pass that would double storage. It has no effect
+// unless WithDecryptionKeys is also set.
+func WithLazyDecrypt() RemoteOpt {
+	return func(_ *Client, c *RemoteContext) error {
+		c.LazyDecrypt = true
+		return nil
+	}
+}
+
+// decryptingContentStoreUnpackOpt builds the UnpackOpt that
+// WithDecryptionKeys installs into c.UnpackOpts, which Image.Unpack applies
+// to its UnpackConfig the same way it applies every other UnpackOpt. When
+// run, it swaps in a imgenc.DecryptingProvider for reads and recomputes the
+// unpack's layer list through imgenc.GetLogicalImageLayerDescriptors, so the
+// differ/applier see the plaintext digests a decrypted read will actually
+// produce instead of the ciphertext digests images.GetImageLayerDescriptors
+// would otherwise report for an encrypted layer.
+func decryptingContentStoreUnpackOpt(c *RemoteContext) UnpackOpt {
+	return func(ctx context.Context, uc *UnpackConfig) error {
+		if c.CryptoConfig == nil || !c.LazyDecrypt || c.CryptoConfig.DecryptConfig == nil {
+			return nil
+		}
+
+		decrypting := &imgenc.DecryptingProvider{
+			Provider:      uc.ContentStore,
+			DecryptConfig: c.CryptoConfig.DecryptConfig,
+		}
+
+		layers, err := imgenc.GetLogicalImageLayerDescriptors(ctx, uc.ContentStore, uc.Image)
+		if err != nil {
+			return err
+		}
+
+		uc.ContentStore = decrypting
+		uc.LayerDescriptors = layers
+		return nil
+	}
+}